@@ -0,0 +1,85 @@
+package tritonhttp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandleAutoIndexListsEntriesSortedDirsFirst(t *testing.T) {
+	docRoot := t.TempDir()
+	dir := filepath.Join(docRoot, "sub")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b.txt", "a.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "zzz-dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{DocRoot: docRoot, AutoIndex: true}
+	req := &Request{Method: "GET", Proto: responseProto}
+	res := s.HandleAutoIndex(req, dir, "/sub/", docRoot)
+
+	if res.StatusCode != statusOK {
+		t.Fatalf("StatusCode = %d, want %d", res.StatusCode, statusOK)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	html := string(body)
+
+	dirIdx := strings.Index(html, "zzz-dir")
+	aIdx := strings.Index(html, "a.txt")
+	bIdx := strings.Index(html, "b.txt")
+	if dirIdx < 0 || aIdx < 0 || bIdx < 0 {
+		t.Fatalf("missing entries in listing:\n%s", html)
+	}
+	if !(dirIdx < aIdx && aIdx < bIdx) {
+		t.Errorf("want directories first, then files sorted by name; got offsets zzz-dir=%d a.txt=%d b.txt=%d", dirIdx, aIdx, bIdx)
+	}
+}
+
+func TestHandleAutoIndexSkipsEscapingSymlink(t *testing.T) {
+	docRoot := t.TempDir()
+	dir := filepath.Join(docRoot, "sub")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret.txt")
+	if err := os.WriteFile(secret, []byte("shh"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "escape")); err != nil {
+		t.Skipf("symlinks unsupported on this platform: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "visible.txt"), []byte("hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{DocRoot: docRoot, AutoIndex: true}
+	req := &Request{Method: "GET", Proto: responseProto}
+	res := s.HandleAutoIndex(req, dir, "/sub/", docRoot)
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	html := string(body)
+
+	if strings.Contains(html, "escape") {
+		t.Errorf("listing should not include a symlink escaping docRoot:\n%s", html)
+	}
+	if !strings.Contains(html, "visible.txt") {
+		t.Errorf("listing should still include an ordinary file:\n%s", html)
+	}
+}
@@ -0,0 +1,106 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteChunkedBody(t *testing.T) {
+	res := &Response{Body: strings.NewReader("hello world")}
+
+	var buf bytes.Buffer
+	if err := res.writeChunkedBody(&buf); err != nil {
+		t.Fatalf("writeChunkedBody: %v", err)
+	}
+
+	want := "b\r\nhello world\r\n0\r\n\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("writeChunkedBody wrote %q, want %q", got, want)
+	}
+}
+
+func TestReadChunkedBody(t *testing.T) {
+	raw := "4\r\nWiki\r\n5\r\npedia\r\n0\r\n\r\n"
+	br := bufio.NewReader(strings.NewReader(raw))
+
+	body, err := readChunkedBody(br)
+	if err != nil {
+		t.Fatalf("readChunkedBody: %v", err)
+	}
+	if got := string(body); got != "Wikipedia" {
+		t.Errorf("readChunkedBody = %q, want %q", got, "Wikipedia")
+	}
+}
+
+func TestReadChunkedBodyWithTrailer(t *testing.T) {
+	raw := "3\r\nfoo\r\n0\r\nX-Trailer: ignored\r\n\r\n"
+	br := bufio.NewReader(strings.NewReader(raw))
+
+	body, err := readChunkedBody(br)
+	if err != nil {
+		t.Fatalf("readChunkedBody: %v", err)
+	}
+	if got := string(body); got != "foo" {
+		t.Errorf("readChunkedBody = %q, want %q", got, "foo")
+	}
+}
+
+func TestReadChunkedBodyRejectsOversizedChunk(t *testing.T) {
+	raw := "174876e800\r\n"
+	br := bufio.NewReader(strings.NewReader(raw))
+
+	if _, err := readChunkedBody(br); err == nil {
+		t.Fatal("readChunkedBody: want error for an oversized chunk size, got nil")
+	}
+}
+
+func TestReadChunkedBodyRejectsNegativeSize(t *testing.T) {
+	raw := "-1\r\n"
+	br := bufio.NewReader(strings.NewReader(raw))
+
+	if _, err := readChunkedBody(br); err == nil {
+		t.Fatal("readChunkedBody: want error for a negative chunk size, got nil")
+	}
+}
+
+func TestReadChunkedBodyRejectsOversizedTotal(t *testing.T) {
+	// Each chunk is small enough on its own, but enough of them pushes
+	// the running total past maxChunkedBodySize.
+	var raw strings.Builder
+	chunk := strings.Repeat("a", 1<<20) // 1MB
+	for i := 0; i < 11; i++ {
+		raw.WriteString("100000\r\n")
+		raw.WriteString(chunk)
+		raw.WriteString("\r\n")
+	}
+	raw.WriteString("0\r\n\r\n")
+	br := bufio.NewReader(strings.NewReader(raw.String()))
+
+	if _, err := readChunkedBody(br); err == nil {
+		t.Fatal("readChunkedBody: want error once the running total exceeds the cap, got nil")
+	}
+}
+
+// TestChunkedRoundTrip ties writeChunkedBody and readChunkedBody
+// together: encoding then decoding an arbitrary body should return it
+// unchanged.
+func TestChunkedRoundTrip(t *testing.T) {
+	const want = "the quick brown fox jumps over the lazy dog, repeated a few times: " +
+		"the quick brown fox jumps over the lazy dog"
+
+	res := &Response{Body: strings.NewReader(want)}
+	var buf bytes.Buffer
+	if err := res.writeChunkedBody(&buf); err != nil {
+		t.Fatalf("writeChunkedBody: %v", err)
+	}
+
+	got, err := readChunkedBody(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("readChunkedBody: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("round trip = %q, want %q", got, want)
+	}
+}
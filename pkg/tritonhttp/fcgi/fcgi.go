@@ -0,0 +1,353 @@
+// Package fcgi implements just enough of the FastCGI protocol (see the
+// FastCGI spec, section "Protocol") to act as a client that forwards a
+// single request to a RESPONDER application and reads back its response.
+package fcgi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	fcgiVersion1 = 1
+
+	roleResponder = 1
+
+	typeBeginRequest = 1
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+
+	flagKeepConn = 0 // we always close after finishing a request
+
+	headerLen = 8
+
+	// maxRecordContent is the largest content a single record can carry,
+	// since contentLength is a 16-bit field.
+	maxRecordContent = 65535
+)
+
+// Client talks the FastCGI protocol to a single RESPONDER application
+// reachable over a TCP or Unix socket.
+type Client struct {
+	Network string // "tcp" or "unix"
+	Addr    string
+}
+
+// NewClient returns a Client that dials network/addr for every request.
+func NewClient(network, addr string) *Client {
+	return &Client{Network: network, Addr: addr}
+}
+
+// header is the 8-byte FastCGI record header.
+type header struct {
+	Version       uint8
+	Type          uint8
+	RequestID     uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+func (h header) bytes() []byte {
+	b := make([]byte, headerLen)
+	b[0] = h.Version
+	b[1] = h.Type
+	binary.BigEndian.PutUint16(b[2:4], h.RequestID)
+	binary.BigEndian.PutUint16(b[4:6], h.ContentLength)
+	b[6] = h.PaddingLength
+	b[7] = h.Reserved
+	return b
+}
+
+// Do forwards params (CGI-style environment variables) and stdin to the
+// FastCGI responder. It returns as soon as the status code and headers
+// have been parsed off the front of the response, without waiting for
+// the rest of the body: body streams the remaining bytes on demand, so
+// a large or slow dynamic response is never fully buffered in memory.
+// The caller must Close body once done with it (including on error) to
+// release the underlying connection.
+func (c *Client) Do(params map[string]string, stdin []byte) (statusCode int, header map[string]string, body io.ReadCloser, err error) {
+	conn, err := net.Dial(c.Network, c.Addr)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("fcgi: dial %v: %v", c.Addr, err)
+	}
+
+	const requestID = 1
+
+	if err := writeRecord(conn, typeBeginRequest, requestID, beginRequestBody()); err != nil {
+		conn.Close()
+		return 0, nil, nil, err
+	}
+	if err := writeParams(conn, requestID, params); err != nil {
+		conn.Close()
+		return 0, nil, nil, err
+	}
+	if err := writeStdin(conn, requestID, stdin); err != nil {
+		conn.Close()
+		return 0, nil, nil, err
+	}
+
+	return readResponse(conn, requestID)
+}
+
+func beginRequestBody() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint16(b[0:2], roleResponder)
+	b[2] = flagKeepConn
+	// b[3:8] reserved, left zero
+	return b
+}
+
+// writeRecord writes a single record, padding the content out to a
+// multiple of 8 bytes as recommended by the spec.
+func writeRecord(w io.Writer, recType uint8, requestID uint16, content []byte) error {
+	if len(content) > maxRecordContent {
+		return fmt.Errorf("fcgi: record content too large: %d bytes", len(content))
+	}
+	padding := (8 - (len(content) % 8)) % 8
+	h := header{
+		Version:       fcgiVersion1,
+		Type:          recType,
+		RequestID:     requestID,
+		ContentLength: uint16(len(content)),
+		PaddingLength: uint8(padding),
+	}
+	if _, err := w.Write(h.bytes()); err != nil {
+		return err
+	}
+	if len(content) > 0 {
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+	}
+	if padding > 0 {
+		if _, err := w.Write(make([]byte, padding)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeParams encodes params as FastCGI name/value pairs, splits them
+// across as many PARAMS records as necessary, and terminates the stream
+// with an empty PARAMS record.
+func writeParams(w io.Writer, requestID uint16, params map[string]string) error {
+	var buf []byte
+	for name, value := range params {
+		buf = append(buf, encodeNameValue(name, value)...)
+	}
+
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > maxRecordContent {
+			n = maxRecordContent
+		}
+		if err := writeRecord(w, typeParams, requestID, buf[:n]); err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return writeRecord(w, typeParams, requestID, nil)
+}
+
+// writeStdin writes stdin (possibly empty, e.g. for GET) as one or more
+// STDIN records, terminated by an empty STDIN record.
+func writeStdin(w io.Writer, requestID uint16, stdin []byte) error {
+	for len(stdin) > 0 {
+		n := len(stdin)
+		if n > maxRecordContent {
+			n = maxRecordContent
+		}
+		if err := writeRecord(w, typeStdin, requestID, stdin[:n]); err != nil {
+			return err
+		}
+		stdin = stdin[n:]
+	}
+	return writeRecord(w, typeStdin, requestID, nil)
+}
+
+// encodeNameValue encodes a single name/value pair using FastCGI's
+// short (1-byte) or long (4-byte, high bit set) length encoding.
+func encodeNameValue(name, value string) []byte {
+	var b []byte
+	b = appendLen(b, len(name))
+	b = appendLen(b, len(value))
+	b = append(b, name...)
+	b = append(b, value...)
+	return b
+}
+
+func appendLen(b []byte, n int) []byte {
+	if n < 128 {
+		return append(b, byte(n))
+	}
+	var lb [4]byte
+	binary.BigEndian.PutUint32(lb[:], uint32(n)|(1<<31))
+	return append(b, lb[:]...)
+}
+
+// readResponse reads STDOUT/STDERR records off conn just long enough to
+// parse the CGI header block at the front of STDOUT, then hands the
+// rest of the exchange off to relayStdout and returns immediately with
+// a body reader fed by it, so the caller can start writing a response
+// to its own client before the FastCGI application has finished.
+func readResponse(conn net.Conn, requestID uint16) (statusCode int, header map[string]string, body io.ReadCloser, err error) {
+	statusCode = 200
+	br := bufio.NewReader(conn)
+	var stdout []byte
+
+	for {
+		content, recType, recID, err := readRecord(br)
+		if err != nil {
+			conn.Close()
+			return 0, nil, nil, err
+		}
+		if recID != requestID {
+			continue
+		}
+
+		switch recType {
+		case typeStdout:
+			stdout = append(stdout, content...)
+			if rest, hdrs, status, ok := parseCGIHeader(stdout); ok {
+				pr, pw := io.Pipe()
+				go relayStdout(conn, br, requestID, pw, rest)
+				return status, hdrs, pr, nil
+			}
+		case typeStderr:
+			// Application diagnostics; nothing to forward to the client.
+		case typeEndRequest:
+			// END_REQUEST arrived without ever completing a CGI header
+			// block (e.g. a completely empty response); treat whatever
+			// STDOUT we did see as a headerless body.
+			conn.Close()
+			return statusCode, map[string]string{}, io.NopCloser(bytes.NewReader(stdout)), nil
+		}
+	}
+}
+
+// relayStdout continues reading FastCGI records off conn (via br) after
+// the CGI header has already been parsed, writing further STDOUT
+// content to pw so a caller reading from the pipe streams the body
+// without it ever sitting fully in memory. rest is the tail of STDOUT
+// read (but not yet delivered) before the header was recognized. It
+// always closes conn, either once END_REQUEST arrives or as soon as pw
+// reports its reader gave up.
+func relayStdout(conn net.Conn, br *bufio.Reader, requestID uint16, pw *io.PipeWriter, rest []byte) {
+	defer conn.Close()
+
+	if len(rest) > 0 {
+		if _, err := pw.Write(rest); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+	}
+
+	for {
+		content, recType, recID, err := readRecord(br)
+		if err != nil {
+			pw.CloseWithError(fmt.Errorf("fcgi: reading record: %v", err))
+			return
+		}
+		if recID != requestID {
+			continue
+		}
+
+		switch recType {
+		case typeStdout:
+			if len(content) > 0 {
+				if _, err := pw.Write(content); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+		case typeStderr:
+			// Application diagnostics; nothing to forward to the client.
+		case typeEndRequest:
+			pw.Close()
+			return
+		}
+	}
+}
+
+// readRecord reads a single FastCGI record off br, including and
+// discarding its padding, and returns its content along with its type
+// and request ID.
+func readRecord(br *bufio.Reader) (content []byte, recType uint8, requestID uint16, err error) {
+	var h [headerLen]byte
+	if _, err := io.ReadFull(br, h[:]); err != nil {
+		return nil, 0, 0, fmt.Errorf("fcgi: reading record header: %v", err)
+	}
+	recType = h[1]
+	requestID = binary.BigEndian.Uint16(h[2:4])
+	contentLength := binary.BigEndian.Uint16(h[4:6])
+	paddingLength := h[6]
+
+	content = make([]byte, contentLength)
+	if _, err := io.ReadFull(br, content); err != nil {
+		return nil, 0, 0, fmt.Errorf("fcgi: reading record content: %v", err)
+	}
+	if paddingLength > 0 {
+		if _, err := io.CopyN(io.Discard, br, int64(paddingLength)); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	return content, recType, requestID, nil
+}
+
+// parseCGIHeader looks for the blank line ("\r\n\r\n") that ends the CGI
+// header block. It returns the body bytes seen after that blank line
+// (which may be empty), the parsed headers, the status code derived
+// from a "Status:" header (defaulting to 200), and whether the header
+// block was found at all.
+func parseCGIHeader(buf []byte) (body []byte, header map[string]string, status int, ok bool) {
+	idx := indexHeaderEnd(buf)
+	if idx < 0 {
+		return nil, nil, 0, false
+	}
+
+	header = make(map[string]string)
+	status = 200
+
+	block := string(buf[:idx])
+	for _, line := range strings.Split(block, "\r\n") {
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		val := strings.TrimSpace(parts[1])
+		if strings.EqualFold(key, "Status") {
+			if n, err := strconv.Atoi(strings.Fields(val)[0]); err == nil {
+				status = n
+			}
+			continue
+		}
+		header[key] = val
+	}
+
+	return buf[idx+4:], header, status, true
+}
+
+// indexHeaderEnd returns the index of the "\r\n\r\n" that terminates the
+// CGI header block, or -1 if it hasn't arrived yet.
+func indexHeaderEnd(buf []byte) int {
+	for i := 0; i+3 < len(buf); i++ {
+		if buf[i] == '\r' && buf[i+1] == '\n' && buf[i+2] == '\r' && buf[i+3] == '\n' {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,139 @@
+package fcgi
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// fakeResponder accepts a single connection, drains the BEGIN_REQUEST/
+// PARAMS/STDIN records Client.Do sends (ignoring their content), then
+// writes the given STDOUT records (each already including a CGI header
+// block split across them, or not) followed by END_REQUEST.
+func fakeResponder(t *testing.T, ln net.Listener, stdoutChunks ...[]byte) {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Errorf("fakeResponder: accept: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	const requestID = 1
+
+	// Drain records until the empty STDIN record that terminates the
+	// request body.
+	for {
+		recType, content, err := readTestRecord(conn)
+		if err != nil {
+			t.Errorf("fakeResponder: reading request record: %v", err)
+			return
+		}
+		if recType == typeStdin && len(content) == 0 {
+			break
+		}
+	}
+
+	for _, chunk := range stdoutChunks {
+		if err := writeRecord(conn, typeStdout, requestID, chunk); err != nil {
+			t.Errorf("fakeResponder: writing STDOUT: %v", err)
+			return
+		}
+	}
+	if err := writeRecord(conn, typeEndRequest, requestID, make([]byte, 8)); err != nil {
+		t.Errorf("fakeResponder: writing END_REQUEST: %v", err)
+	}
+}
+
+func readTestRecord(conn net.Conn) (recType uint8, content []byte, err error) {
+	var h [headerLen]byte
+	if _, err := io.ReadFull(conn, h[:]); err != nil {
+		return 0, nil, err
+	}
+	recType = h[1]
+	contentLength := binary.BigEndian.Uint16(h[4:6])
+	paddingLength := h[6]
+
+	content = make([]byte, contentLength)
+	if _, err := io.ReadFull(conn, content); err != nil {
+		return 0, nil, err
+	}
+	if paddingLength > 0 {
+		if _, err := io.CopyN(io.Discard, conn, int64(paddingLength)); err != nil {
+			return 0, nil, err
+		}
+	}
+	return recType, content, nil
+}
+
+func TestClientDoSingleRecord(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go fakeResponder(t, ln, []byte("Status: 201 Created\r\nContent-Type: text/plain\r\n\r\nhello world"))
+
+	client := NewClient("tcp", ln.Addr().String())
+	statusCode, header, body, err := client.Do(map[string]string{"REQUEST_METHOD": "GET"}, nil)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer body.Close()
+
+	if statusCode != 201 {
+		t.Errorf("statusCode = %d, want 201", statusCode)
+	}
+	if header["Content-Type"] != "text/plain" {
+		t.Errorf("Content-Type = %q, want %q", header["Content-Type"], "text/plain")
+	}
+
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if string(got) != "hello world" {
+		t.Errorf("body = %q, want %q", got, "hello world")
+	}
+}
+
+// TestClientDoHeaderSplitAcrossRecords exercises the case the review
+// flagged: the CGI header block isn't necessarily in the first STDOUT
+// record Do sees, and further body bytes keep arriving in later
+// records after Do has already returned.
+func TestClientDoHeaderSplitAcrossRecords(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go fakeResponder(t, ln,
+		[]byte("Content-Type: text/pla"),
+		[]byte("in\r\n\r\nfirst "),
+		[]byte("second"),
+	)
+
+	client := NewClient("tcp", ln.Addr().String())
+	statusCode, header, body, err := client.Do(nil, nil)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer body.Close()
+
+	if statusCode != 200 {
+		t.Errorf("statusCode = %d, want 200 (default)", statusCode)
+	}
+	if header["Content-Type"] != "text/plain" {
+		t.Errorf("Content-Type = %q, want %q", header["Content-Type"], "text/plain")
+	}
+
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if string(got) != "first second" {
+		t.Errorf("body = %q, want %q", got, "first second")
+	}
+}
@@ -0,0 +1,176 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMatchVirtualHostLongestPrefixWins(t *testing.T) {
+	s := &Server{VirtualHosts: []VirtualHost{
+		{Host: "example.com", DocRoot: "/var/www/root"},
+		{Host: "example.com", PathPrefix: "/api", Upstream: "http://127.0.0.1:9000"},
+		{Host: "example.com", PathPrefix: "/api/v2", Upstream: "http://127.0.0.1:9001"},
+		{Host: "other.com", DocRoot: "/var/www/other"},
+	}}
+
+	tests := []struct {
+		host, url string
+		wantRoot  string // DocRoot or Upstream of the expected match
+	}{
+		{"example.com", "/api/v2/widgets", "http://127.0.0.1:9001"},
+		{"example.com", "/api/v1/widgets", "http://127.0.0.1:9000"},
+		{"example.com", "/index.html", "/var/www/root"},
+		{"other.com", "/index.html", "/var/www/other"},
+	}
+	for _, tt := range tests {
+		req := &Request{Host: tt.host, URL: tt.url}
+		vh, ok := s.matchVirtualHost(req)
+		if !ok {
+			t.Errorf("matchVirtualHost(%q, %q): no match, want %q", tt.host, tt.url, tt.wantRoot)
+			continue
+		}
+		got := vh.DocRoot
+		if got == "" {
+			got = vh.Upstream
+		}
+		if got != tt.wantRoot {
+			t.Errorf("matchVirtualHost(%q, %q) = %q, want %q", tt.host, tt.url, got, tt.wantRoot)
+		}
+	}
+
+	if _, ok := s.matchVirtualHost(&Request{Host: "unknown.com", URL: "/"}); ok {
+		t.Error("matchVirtualHost: matched a Host with no VirtualHost entry")
+	}
+}
+
+func TestWriteProxyRequestStripsHopByHopAndChainsForwardedFor(t *testing.T) {
+	req := &Request{
+		Method:     "GET",
+		URL:        "/widgets",
+		Proto:      responseProto,
+		Host:       "example.com",
+		RemoteAddr: "203.0.113.5:54321",
+	}
+	req.Header.Set("Connection", "keep-alive") // hop-by-hop; ReadRequest would put this in Close, not Header
+	req.Header.Set("Te", "trailers")            // hop-by-hop
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	req.Header.Set("Accept", "text/html")
+
+	upstream, err := url.Parse("http://upstream.internal:9000/app")
+	if err != nil {
+		t.Fatalf("parsing upstream URL: %v", err)
+	}
+
+	var buf strings.Builder
+	if err := writeProxyRequest(&buf, req, upstream); err != nil {
+		t.Fatalf("writeProxyRequest: %v", err)
+	}
+	out := buf.String()
+
+	if !strings.HasPrefix(out, "GET /app/widgets HTTP/1.1\r\n") {
+		t.Errorf("request line = %q", strings.SplitN(out, "\r\n", 2)[0])
+	}
+	if strings.Contains(out, "Te:") || strings.Contains(out, "te:") {
+		t.Errorf("hop-by-hop header Te leaked through:\n%s", out)
+	}
+	if !strings.Contains(out, "Host: upstream.internal:9000\r\n") {
+		t.Errorf("Host header not rewritten to upstream:\n%s", out)
+	}
+	if !strings.Contains(out, "X-Forwarded-For: 198.51.100.9, 203.0.113.5\r\n") {
+		t.Errorf("X-Forwarded-For chain not appended:\n%s", out)
+	}
+	if !strings.Contains(out, "X-Forwarded-Host: example.com\r\n") {
+		t.Errorf("X-Forwarded-Host missing:\n%s", out)
+	}
+	if !strings.Contains(out, "Accept: text/html\r\n") {
+		t.Errorf("ordinary header dropped:\n%s", out)
+	}
+}
+
+func TestReadProxyResponseChunked(t *testing.T) {
+	raw := "HTTP/1.1 200 OK\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n0\r\n\r\n"
+	statusCode, header, body := readProxyResponseFromString(t, raw)
+	defer body.Close()
+
+	if statusCode != 200 {
+		t.Errorf("statusCode = %d, want 200", statusCode)
+	}
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("body = %q, want %q", got, "hello")
+	}
+	if _, ok := header["Transfer-Encoding"]; !ok {
+		t.Errorf("Transfer-Encoding header missing from parsed header map")
+	}
+}
+
+func TestReadProxyResponseContentLength(t *testing.T) {
+	raw := "HTTP/1.1 201 Created\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"helloextra-bytes-not-part-of-body"
+	statusCode, _, body := readProxyResponseFromString(t, raw)
+	defer body.Close()
+
+	if statusCode != 201 {
+		t.Errorf("statusCode = %d, want 201", statusCode)
+	}
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("body = %q, want %q (Content-Length should cap the read)", got, "hello")
+	}
+}
+
+func TestReadProxyResponseNoFramingIsEmpty(t *testing.T) {
+	raw := "HTTP/1.1 204 No Content\r\n" +
+		"\r\n"
+	statusCode, header, body := readProxyResponseFromString(t, raw)
+	defer body.Close()
+
+	if statusCode != 204 {
+		t.Errorf("statusCode = %d, want 204", statusCode)
+	}
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("body = %q, want empty (no Content-Length, no chunked, no Connection: close)", got)
+	}
+	if header["Content-Length"] != "0" {
+		t.Errorf("Content-Length = %q, want %q", header["Content-Length"], "0")
+	}
+}
+
+// readProxyResponseFromString drives readProxyResponse over an in-memory
+// pipe so it gets a real net.Conn (readProxyResponse's signature needs
+// one to build the returned proxyBody) without a real upstream listener.
+func readProxyResponseFromString(t *testing.T, raw string) (statusCode int, header map[string]string, body io.ReadCloser) {
+	t.Helper()
+	client, srv := net.Pipe()
+	go func() {
+		io.Copy(srv, strings.NewReader(raw))
+		srv.Close()
+	}()
+	t.Cleanup(func() { client.Close() })
+
+	br := bufio.NewReader(client)
+	statusCode, header, body, err := readProxyResponse(client, br)
+	if err != nil {
+		t.Fatalf("readProxyResponse: %v", err)
+	}
+	return statusCode, header, body
+}
@@ -0,0 +1,130 @@
+package tritonhttp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = int64(100)
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStart  int64
+		wantLength int64
+		wantOK     bool
+	}{
+		{"start-end", "bytes=0-49", 0, 50, true},
+		{"start-only", "bytes=50-", 50, 50, true},
+		{"suffix", "bytes=-20", 80, 20, true},
+		{"suffix clamped to file size", "bytes=-1000", 0, 100, true},
+		{"end clamped to last byte", "bytes=90-1000", 90, 10, true},
+		{"single byte", "bytes=0-0", 0, 1, true},
+		{"whole file via start-only at 0", "bytes=0-", 0, 100, true},
+		{"missing bytes= prefix", "0-49", 0, 0, false},
+		{"multiple ranges unsupported", "bytes=0-10,20-30", 0, 0, false},
+		{"start beyond size", "bytes=100-", 0, 0, false},
+		{"start equal to size", "bytes=100-150", 0, 0, false},
+		{"end before start", "bytes=50-10", 0, 0, false},
+		{"zero-length suffix", "bytes=-0", 0, 0, false},
+		{"garbage", "bytes=abc-def", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, length, ok := parseRange(tt.header, size)
+			if ok != tt.wantOK {
+				t.Fatalf("parseRange(%q, %d) ok = %v, want %v", tt.header, size, ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if start != tt.wantStart || length != tt.wantLength {
+				t.Errorf("parseRange(%q, %d) = (%d, %d), want (%d, %d)",
+					tt.header, size, start, length, tt.wantStart, tt.wantLength)
+			}
+		})
+	}
+}
+
+func TestParseRangeEmptyFile(t *testing.T) {
+	if _, _, ok := parseRange("bytes=-10", 0); ok {
+		t.Errorf("parseRange on an empty file should be unsatisfiable")
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	modTime := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	etag := weakETag(1234, modTime)
+
+	tests := []struct {
+		name string
+		req  *Request
+		want bool
+	}{
+		{
+			name: "If-None-Match exact match",
+			req:  reqWithHeader("If-None-Match", etag),
+			want: true,
+		},
+		{
+			name: "If-None-Match wildcard",
+			req:  reqWithHeader("If-None-Match", "*"),
+			want: true,
+		},
+		{
+			name: "If-None-Match mismatch",
+			req:  reqWithHeader("If-None-Match", `W/"deadbeef"`),
+			want: false,
+		},
+		{
+			name: "If-Modified-Since after mod time",
+			req:  reqWithHeader("If-Modified-Since", modTime.Add(time.Hour).Format(time.RFC1123)),
+			want: true,
+		},
+		{
+			name: "If-Modified-Since equal to mod time",
+			req:  reqWithHeader("If-Modified-Since", modTime.Format(time.RFC1123)),
+			want: true,
+		},
+		{
+			name: "If-Modified-Since before mod time",
+			req:  reqWithHeader("If-Modified-Since", modTime.Add(-time.Hour).Format(time.RFC1123)),
+			want: false,
+		},
+		{
+			name: "If-None-Match takes precedence over If-Modified-Since",
+			req: reqWithHeaders(map[string]string{
+				"If-None-Match":     `W/"deadbeef"`,
+				"If-Modified-Since": modTime.Add(time.Hour).Format(time.RFC1123),
+			}),
+			want: false,
+		},
+		{
+			name: "neither header present",
+			req:  &Request{},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := notModified(tt.req, modTime, etag); got != tt.want {
+				t.Errorf("notModified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func reqWithHeader(key, value string) *Request {
+	return reqWithHeaders(map[string]string{key: value})
+}
+
+func reqWithHeaders(headers map[string]string) *Request {
+	req := &Request{}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return req
+}
@@ -2,10 +2,68 @@ package tritonhttp
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
+	"sync"
+	"unsafe"
 )
 
+// b2s reinterprets b as a string without copying its bytes: a string
+// header is an address and a length, a prefix of a slice header, so
+// the underlying bits are already in the right shape. This is the same
+// trick fasthttp uses to keep request parsing allocation-free. The
+// returned string aliases b's backing array for as long as that array
+// exists, so ReadRequest only ever calls it on slices of req.buf (see
+// appendLine) — never directly on a line returned by br.ReadSlice. A
+// bufio.Reader is free to overwrite or compact its internal buffer the
+// next time it needs more data (e.g. if the request arrives split
+// across reads, or its headers overflow the default 4KB buffer), which
+// would otherwise silently corrupt any string built from it.
+func b2s(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// headerField is one parsed request header as a name/value pair.
+type headerField struct {
+	Name  string
+	Value string
+}
+
+// Header is a request's headers, stored as a small slice rather than a
+// map[string]string: a typical request carries only a handful of
+// headers, and a linear scan over them avoids both the map allocation
+// and the hashing cost a map[string]string needs for so few entries.
+// Names must already be in canonical form (see CanonicalHeaderKey).
+type Header []headerField
+
+// Get returns the value of the first field named key, and whether it
+// was present.
+func (h Header) Get(key string) (string, bool) {
+	for _, f := range h {
+		if f.Name == key {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set adds key=value, or overwrites the value already stored for key.
+func (h *Header) Set(key, value string) {
+	for i := range *h {
+		if (*h)[i].Name == key {
+			(*h)[i].Value = value
+			return
+		}
+	}
+	*h = append(*h, headerField{key, value})
+}
+
 type Request struct {
 	Method string // e.g. "GET"
 	URL    string // e.g. "/path/to/a/file"
@@ -13,12 +71,52 @@ type Request struct {
 
 	// Header stores misc headers excluding "Host" and "Connection",
 	// which are stored in special fields below.
-	// Header keys are case-incensitive, and should be stored
-	// in the canonical format in this map.
-	Header map[string]string
+	Header Header
 
 	Host  string // determine from the "Host" header
 	Close bool   // determine from the "Connection" header
+
+	// Body holds the request body, if any, decoded from a chunked
+	// "Transfer-Encoding" (only GET is currently supported and GET
+	// bodies are always empty, but forwarding scenarios such as the
+	// FastCGI/reverse-proxy handlers may carry one through).
+	Body []byte
+
+	// RemoteAddr is the client's address, filled in by HandleConnection
+	// from the accepted net.Conn. Used for things like the reverse
+	// proxy's X-Forwarded-For.
+	RemoteAddr string
+
+	// buf owns the bytes behind Method, URL, Proto, Host and Header:
+	// ReadRequest copies each start-line/header line into buf (see
+	// appendLine) before taking any b2s reference into it, so those
+	// strings stay valid for req's lifetime regardless of what the
+	// connection's bufio.Reader does with its own buffer afterwards.
+	buf []byte
+}
+
+// requestPool lets ReadRequest reuse a Request (and its Header and buf
+// backing arrays) across requests instead of allocating a fresh one
+// every time.
+var requestPool = sync.Pool{
+	New: func() any { return new(Request) },
+}
+
+// newRequest pulls a Request off requestPool, keeping its Header and
+// buf backing arrays (both truncated to length 0) rather than
+// discarding them.
+func newRequest() *Request {
+	req := requestPool.Get().(*Request)
+	header := req.Header[:0]
+	buf := req.buf[:0]
+	*req = Request{Header: header, buf: buf}
+	return req
+}
+
+// Release returns req to requestPool for reuse by a later ReadRequest
+// call. The caller must not use req again afterwards.
+func (req *Request) Release() {
+	requestPool.Put(req)
 }
 
 // ReadRequest tries to read the next valid request from br.
@@ -30,44 +128,34 @@ type Request struct {
 // and a nil request. In this case, bytesReceived indicates whether or not
 // some bytes are received before the error occurs. This is useful to determine
 // the timeout with partial request received condition.
+//
+// ReadRequest parses directly out of br, via ReadSlice, rather than
+// building a []string of header lines and a map[string]string. Each
+// line is first copied into req.buf (see appendLine) — one copy per
+// line, not a further allocation per field — and only then sliced with
+// b2s, so the resulting strings stay valid even after br's internal
+// buffer is reused or compacted by a later read.
 func ReadRequest(br *bufio.Reader) (req *Request, bytesReceived bool, err error) {
-	req = &Request{}
-
-	_, errByteReceived := br.Peek(1)
-	if errByteReceived != nil {
-		bytesReceived = false
-		return nil, bytesReceived, errByteReceived
-	} else {
-		bytesReceived = true
+	if _, err := br.Peek(1); err != nil {
+		return nil, false, err
 	}
+	bytesReceived = true
 
-	// Read start line
-	var requestLineArr []string
+	req = newRequest()
 
-	for temp_line, err := ReadLine(br); temp_line != ""; {
-		if err != nil {
-			return nil, bytesReceived, err
-		}
-		requestLineArr = append(requestLineArr, temp_line)
-		temp_line, err = ReadLine(br)
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		return nil, bytesReceived, err
 	}
-	// fmt.Print(len(requestLineArr))
-
-	// Deal with empty bad request
-	if len(requestLineArr) == 0 {
-		requestLineArr = append(requestLineArr, "Empty bad request")
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return nil, bytesReceived, fmt.Errorf("Empty bad request")
 	}
+	start, end := appendLine(req, line)
 
-	// First element of a vaild arr should looks like "GET /images/myimg.jpg HTTP/1.1"
-	startLine, err := parseStartLine(requestLineArr[0])
-	if err != nil {
+	if err := parseStartLine(req.buf[start:end], req); err != nil {
 		return nil, bytesReceived, err
 	}
-	req.Method = startLine[0]
-	req.URL = startLine[1]
-	req.Proto = startLine[2]
-	req.Close = false
-	headerMap := make(map[string]string)
 
 	if !validMethod(req.Method) {
 		return nil, bytesReceived, fmt.Errorf("Bad Method.")
@@ -79,49 +167,156 @@ func ReadRequest(br *bufio.Reader) (req *Request, bytesReceived bool, err error)
 		return nil, bytesReceived, fmt.Errorf("Bad Proto.")
 	}
 
-	// Read headers
-	// Check required headers
-	// Handle special headers
-	haveHost := 0
-	for _, header := range requestLineArr[1:] {
-		key, val, err := parseHeader(header)
-
+	haveHost := false
+	for {
+		line, err := br.ReadSlice('\n')
 		if err != nil {
 			return nil, bytesReceived, err
 		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			break
+		}
+		start, end := appendLine(req, line)
+		hdrLine := req.buf[start:end]
+
+		idx := bytes.IndexByte(hdrLine, ':')
+		if idx < 0 {
+			return nil, bytesReceived, fmt.Errorf("Bad header")
+		}
+		rawKey := hdrLine[:idx]
+		if !validKeyBytes(rawKey) {
+			return nil, bytesReceived, fmt.Errorf("Bad key")
+		}
+		value := b2s(bytes.TrimLeft(hdrLine[idx+1:], " "))
+		key := CanonicalHeaderKey(b2s(rawKey))
 
-		if key == "Host" {
-			haveHost = 1
-			req.Host = val
-		} else if key == "Connection" {
-			if val == "close" {
-				req.Close = true // Deal with invalid connection instructions?
+		switch key {
+		case "Host":
+			haveHost = true
+			req.Host = value
+		case "Connection":
+			if value == "close" {
+				req.Close = true
 			}
-		} else {
-			headerMap[key] = val
+		default:
+			req.Header.Set(key, value)
 		}
 	}
-	req.Header = headerMap
-	// fmt.Print(req.Header)
 
-	if haveHost == 0 {
+	if !haveHost {
 		return nil, bytesReceived, fmt.Errorf("Missing Host.")
 	}
 
+	_, hasContentLength := req.Header.Get("Content-Length")
+	transferEncoding, hasTransferEncoding := req.Header.Get("Transfer-Encoding")
+	if hasContentLength && hasTransferEncoding {
+		return nil, bytesReceived, fmt.Errorf("Content-Length and Transfer-Encoding cannot both be set.")
+	}
+	if hasTransferEncoding && transferEncoding == "chunked" {
+		body, err := readChunkedBody(br)
+		if err != nil {
+			return nil, bytesReceived, err
+		}
+		req.Body = body
+	}
+
 	// Return valid request
 	return req, bytesReceived, nil
 }
 
+// maxChunkedBodySize bounds the total size of a chunked request body
+// readChunkedBody will accept. It also caps any single chunk, since a
+// client-supplied chunk-size line is otherwise an unbounded hint: a
+// value like "174876e800" (100GB) would make chunk := make([]byte, size)
+// try to allocate that much in the request-handling goroutine, and a
+// negative value (ParseInt accepts a leading '-') would panic the
+// process with "makeslice: len out of range" — either way taking down
+// the whole server rather than just the offending connection.
+const maxChunkedBodySize = 10 << 20 // 10MB
+
+// readChunkedBody reads a "Transfer-Encoding: chunked" body off br:
+// a series of "<hex-size>\r\n<data>\r\n" chunks terminated by a
+// zero-size chunk and a trailing blank line.
+func readChunkedBody(br *bufio.Reader) ([]byte, error) {
+	var body []byte
+	for {
+		sizeLine, err := ReadLine(br)
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeLine), 16, 64)
+		if err != nil || size < 0 || size > maxChunkedBodySize {
+			return nil, fmt.Errorf("Bad chunk size: %v", sizeLine)
+		}
+		if size == 0 {
+			for {
+				line, err := ReadLine(br)
+				if err != nil {
+					return nil, err
+				}
+				if line == "" {
+					break
+				}
+			}
+			return body, nil
+		}
+		if int64(len(body))+size > maxChunkedBodySize {
+			return nil, fmt.Errorf("chunked body exceeds %d bytes", maxChunkedBodySize)
+		}
+
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		body = append(body, chunk...)
+
+		if _, err := ReadLine(br); err != nil {
+			return nil, err
+		}
+	}
+}
+
 // helper functions below
 
-func parseStartLine(line string) ([]string, error) {
-	fields := strings.SplitN(line, " ", 3)
-	if len(fields) != 3 {
-		return fields, fmt.Errorf("Bad start line: %v", fields)
+// appendLine copies line (already stripped of its line ending) onto
+// the end of req.buf and returns its [start, end) offsets within it.
+// Copying into req.buf, rather than keeping the slice ReadSlice
+// returned, is what lets ReadRequest take b2s references safely: a
+// later append may grow req.buf into a new backing array, but that
+// only changes what req.buf itself points at — a string built from
+// req.buf[start:end] before the grow keeps pointing at the (unchanged)
+// earlier array, and br's internal buffer is never aliased at all.
+func appendLine(req *Request, line []byte) (start, end int) {
+	start = len(req.buf)
+	req.buf = append(req.buf, line...)
+	return start, len(req.buf)
+}
+
+// parseStartLine splits line (already stripped of its line ending,
+// e.g. "GET /images/myimg.jpg HTTP/1.1") into req's Method, URL and
+// Proto, without any intermediate []string allocation.
+func parseStartLine(line []byte, req *Request) error {
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		return fmt.Errorf("Bad start line: %v", string(line))
+	}
+	rest := line[i+1:]
+
+	j := bytes.IndexByte(rest, ' ')
+	if j < 0 {
+		return fmt.Errorf("Bad start line: %v", string(line))
 	}
-	return fields, nil
+
+	req.Method = b2s(line[:i])
+	req.URL = b2s(rest[:j])
+	req.Proto = b2s(rest[j+1:])
+	return nil
 }
 
+// parseHeader splits a "Name: value" header line, as read by ReadLine
+// (used for parsing upstream HTTP responses in the reverse proxy,
+// rather than the ReadRequest hot path above).
 func parseHeader(header string) (string, string, error) {
 	headerArr := strings.SplitN(header, ":", 2)
 	if len(headerArr) != 2 {
@@ -137,6 +332,10 @@ func parseHeader(header string) (string, string, error) {
 }
 
 func validKey(key string) bool {
+	return validKeyBytes([]byte(key))
+}
+
+func validKeyBytes(key []byte) bool {
 	if len(key) == 0 {
 		return false
 	}
@@ -0,0 +1,117 @@
+package tritonhttp
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// repeatingReader replays the same bytes forever, so a benchmark/alloc
+// test can drive ReadRequest without paying for a fresh io.Reader (or
+// bufio.Reader) on every iteration.
+type repeatingReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *repeatingReader) Read(p []byte) (int, error) {
+	if r.pos >= len(r.data) {
+		r.pos = 0
+	}
+	n := copy(p, r.data[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// TestReadRequestAllocs is a regression test, modeled on the fasthttp
+// approach, that ReadRequest's hot path stays allocation-free: it reads
+// a canned request out of a reusable buffer in a loop and asserts
+// testing.AllocsPerRun sees none.
+func TestReadRequestAllocs(t *testing.T) {
+	src := &repeatingReader{data: []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n")}
+	br := bufio.NewReader(src)
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		req, _, err := ReadRequest(br)
+		if err != nil {
+			t.Fatalf("ReadRequest: %v", err)
+		}
+		req.Release()
+	})
+
+	if allocs != 0 {
+		t.Errorf("ReadRequest allocated %.1f times per call, want 0", allocs)
+	}
+}
+
+// oneByteReader forces br.fill() to run once per byte, the way a slow
+// or fragmented client connection would, instead of handing ReadSlice
+// everything it needs in a single underlying Read.
+type oneByteReader struct {
+	data []byte
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, fmt.Errorf("oneByteReader: exhausted")
+	}
+	p[0] = r.data[0]
+	r.data = r.data[1:]
+	return 1, nil
+}
+
+// TestReadRequestFragmentedDelivery guards against ReadRequest aliasing
+// br's internal buffer: bufio.Reader.fill compacts or overwrites that
+// buffer whenever a ReadSlice call needs more data than is already
+// buffered, which happens here on every single byte.
+func TestReadRequestFragmentedDelivery(t *testing.T) {
+	raw := "GET /a/b/c HTTP/1.1\r\nHost: example.com\r\nX-Probe: hello\r\n\r\n"
+	br := bufio.NewReader(&oneByteReader{data: []byte(raw)})
+
+	req, _, err := ReadRequest(br)
+	if err != nil {
+		t.Fatalf("ReadRequest: %v", err)
+	}
+	defer req.Release()
+
+	if req.Method != "GET" || req.URL != "/a/b/c" || req.Proto != responseProto {
+		t.Errorf("got Method=%q URL=%q Proto=%q", req.Method, req.URL, req.Proto)
+	}
+	if req.Host != "example.com" {
+		t.Errorf("got Host=%q, want %q", req.Host, "example.com")
+	}
+	if v, ok := req.Header.Get("X-Probe"); !ok || v != "hello" {
+		t.Errorf("got X-Probe=%q, ok=%v, want %q, true", v, ok, "hello")
+	}
+}
+
+// TestReadRequestOversizedHeaders guards the same aliasing hazard as
+// TestReadRequestFragmentedDelivery, but via the other trigger: a
+// header block larger than br's 4KB buffer, which forces br.fill() to
+// shift its buffer mid-parse even though the source is never short of
+// data.
+func TestReadRequestOversizedHeaders(t *testing.T) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "GET / HTTP/1.1\r\nHost: example.com\r\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&b, "X-Filler-%d: value-%d\r\n", i, i)
+	}
+	b.WriteString("\r\n")
+	raw := b.String()
+
+	br := bufio.NewReader(strings.NewReader(raw))
+
+	req, _, err := ReadRequest(br)
+	if err != nil {
+		t.Fatalf("ReadRequest: %v", err)
+	}
+	defer req.Release()
+
+	if req.Method != "GET" || req.URL != "/" || req.Host != "example.com" {
+		t.Errorf("got Method=%q URL=%q Host=%q", req.Method, req.URL, req.Host)
+	}
+	if v, ok := req.Header.Get("X-Filler-199"); !ok || v != "value-199" {
+		t.Errorf("got X-Filler-199=%q, ok=%v, want %q, true", v, ok, "value-199")
+	}
+}
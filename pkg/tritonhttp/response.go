@@ -9,9 +9,12 @@ import (
 )
 
 var statusText = map[int]string{
-	statusOK:               "OK",
-	statusMethodNotAllowed: "Bad Request",
-	fileNotFound:           "Not Found",
+	statusOK:                  "OK",
+	statusPartialContent:      "Partial Content",
+	statusNotModified:         "Not Modified",
+	statusMethodNotAllowed:    "Bad Request",
+	fileNotFound:              "Not Found",
+	statusRangeNotSatisfiable: "Range Not Satisfiable",
 }
 
 type Response struct {
@@ -30,20 +33,49 @@ type Response struct {
 	// FilePath is the local path to the file to serve.
 	// It could be "", which means there is no file to serve.
 	FilePath string
+
+	// Body, when set, is read for the response body instead of FilePath.
+	// If Header doesn't already carry a "Content-Length" (e.g. the
+	// length isn't known up front, such as a streamed upstream
+	// response), Write sends it with "Transfer-Encoding: chunked"
+	// instead. If Body also implements io.Closer, Write closes it once
+	// done, whether or not writing succeeded.
+	Body io.Reader
+
+	// hasRange, rangeStart and rangeLen describe the byte window of
+	// FilePath to serve for a 206 Partial Content response. When
+	// hasRange is false, WriteBody serves the whole file.
+	hasRange   bool
+	rangeStart int64
+	rangeLen   int64
 }
 
 // Write writes the res to the w.
 func (res *Response) Write(w io.Writer) error {
+	if c, ok := res.Body.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	_, hasContentLength := res.Header["Content-Length"]
+	chunked := res.Body != nil && !hasContentLength
+
+	if chunked {
+		if res.Header == nil {
+			res.Header = make(map[string]string)
+		}
+		res.Header["Transfer-Encoding"] = "chunked"
+	}
+
 	if err := res.WriteStatusLine(w); err != nil {
 		return err
 	}
 	if err := res.WriteSortedHeaders(w); err != nil {
 		return err
 	}
-	if err := res.WriteBody(w); err != nil {
-		return err
+	if chunked {
+		return res.writeChunkedBody(w)
 	}
-	return nil
+	return res.WriteBody(w)
 }
 
 // WriteStatusLine writes the status line of res to w, including the ending "\r\n".
@@ -91,25 +123,79 @@ func (res *Response) WriteSortedHeaders(w io.Writer) error {
 	return nil
 }
 
-// WriteBody writes res' file content as the response body to w.
-// It doesn't write anything if there is no file to serve.
+// WriteBody writes res' body to w: res.Body if set, otherwise the
+// contents of FilePath (or the byte range of it given by rangeStart/
+// rangeLen), streamed straight off disk rather than read in full.
+// It doesn't write anything if there is no body to serve.
 func (res *Response) WriteBody(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	if res.Body != nil {
+		if _, err := io.Copy(bw, res.Body); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
 	if res.FilePath == "" {
 		return nil
 	}
 
-	bw := bufio.NewWriter(w)
-	file, err := os.ReadFile(res.FilePath)
-
+	file, err := os.Open(res.FilePath)
 	if err != nil {
 		return err
 	}
-	if _, err := bw.Write(file); err != nil {
-		return err
+	defer file.Close()
+
+	if !res.hasRange {
+		if _, err := io.Copy(bw, file); err != nil {
+			return err
+		}
+		return bw.Flush()
 	}
-	if err := bw.Flush(); err != nil {
+
+	if res.rangeStart > 0 {
+		if _, err := file.Seek(res.rangeStart, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if _, err := io.CopyN(bw, file, res.rangeLen); err != nil {
 		return err
 	}
 
-	return nil
+	return bw.Flush()
+}
+
+// writeChunkedBody reads res.Body in chunks and writes it to w as
+// "Transfer-Encoding: chunked": each chunk is framed as
+// "<hex-size>\r\n<data>\r\n", with a final "0\r\n\r\n" ending the body.
+func (res *Response) writeChunkedBody(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := res.Body.Read(buf)
+		if n > 0 {
+			if _, werr := fmt.Fprintf(bw, "%x\r\n", n); werr != nil {
+				return werr
+			}
+			if _, werr := bw.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if _, werr := bw.WriteString("\r\n"); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := bw.WriteString("0\r\n\r\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
 }
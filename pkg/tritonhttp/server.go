@@ -2,22 +2,32 @@ package tritonhttp
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/chl131/HTTP-Web-Server/pkg/tritonhttp/fcgi"
 )
 
 const (
-	responseProto          = "HTTP/1.1"
-	statusOK               = 200
-	statusMethodNotAllowed = 400
-	fileNotFound           = 404
+	responseProto             = "HTTP/1.1"
+	statusOK                  = 200
+	statusPartialContent      = 206
+	statusNotModified         = 304
+	statusMethodNotAllowed    = 400
+	fileNotFound              = 404
+	statusRangeNotSatisfiable = 416
 )
 
 type Server struct {
@@ -28,6 +38,44 @@ type Server struct {
 
 	// DocRoot specifies the path to the directory to serve static files from.
 	DocRoot string
+
+	// FastCGI maps a file suffix (e.g. ".php") to the FastCGI responder
+	// that should handle requests for matching paths, instead of the
+	// file being served statically out of DocRoot.
+	FastCGI map[string]FastCGIBackend
+
+	// AutoIndex, when true, makes the server generate an HTML directory
+	// listing for a directory request (a URL ending in "/") that has no
+	// index.html, instead of responding 404.
+	AutoIndex bool
+
+	// AutoIndexTemplate optionally overrides the built-in listing
+	// template. It is executed with an autoIndexData value.
+	AutoIndexTemplate *template.Template
+
+	// VirtualHosts routes a request to a per-host DocRoot or upstream
+	// origin based on its Host header (and, optionally, a path
+	// prefix), instead of always serving out of DocRoot. The first
+	// matching entry with the longest PathPrefix wins.
+	VirtualHosts []VirtualHost
+}
+
+// VirtualHost matches requests by Host header (and optional PathPrefix)
+// to either a per-host DocRoot or an upstream origin to reverse-proxy
+// to. Exactly one of DocRoot or Upstream should be set.
+type VirtualHost struct {
+	Host       string // Host header to match, e.g. "static.example.com"
+	PathPrefix string // optional URL path prefix to also require
+
+	DocRoot  string // serve static files from this directory
+	Upstream string // reverse-proxy to this origin, e.g. "http://127.0.0.1:9000"
+}
+
+// FastCGIBackend identifies a FastCGI responder application reachable
+// over a TCP or Unix socket.
+type FastCGIBackend struct {
+	Network string // "tcp" or "unix"
+	Addr    string
 }
 
 // ListenAndServe listens on the TCP network address s.Addr and then
@@ -131,13 +179,16 @@ func (s *Server) HandleConnection(conn net.Conn) {
 		}
 
 		// Handle good request
+		req.RemoteAddr = conn.RemoteAddr().String()
 		res := s.HandleGoodRequest(req)
 		if err := res.Write(conn); err != nil {
 			fmt.Println(err)
 		}
 
 		// Close conn if requested
-		if req.Close {
+		reqClose := req.Close
+		req.Release()
+		if reqClose {
 			_ = conn.Close()
 			return
 		}
@@ -149,68 +200,602 @@ func (s *Server) HandleConnection(conn net.Conn) {
 func (s *Server) HandleGoodRequest(req *Request) (res *Response) {
 	res = &Response{}
 
+	docRoot := s.DocRoot
+	if vh, ok := s.matchVirtualHost(req); ok {
+		if vh.Upstream != "" {
+			return s.HandleReverseProxy(req, vh)
+		}
+		if vh.DocRoot != "" {
+			docRoot = vh.DocRoot
+		}
+	}
+
 	// Deal with escape and check exist
 	req_url := req.URL
-	if string(req_url[len(req_url)-1]) == "/" {
-		req_url += "index.html"
+	isDirRequest := string(req_url[len(req_url)-1]) == "/"
+	lookupURL := req_url
+	if isDirRequest {
+		lookupURL += "index.html"
+	}
+
+	path, ok := s.resolvePath(docRoot, lookupURL)
+	if !ok {
+		res.HandleNotFound(req)
+		return res
+	}
+
+	if pathExist, _ := exists(path); pathExist {
+		fmt.Printf("%s\n", lookupURL)
+		fmt.Printf("%s\n", path)
+		if backend, ok := s.fastCGIBackend(path); ok {
+			res = s.HandleFastCGI(req, path, backend)
+		} else {
+			res.HandleOK(req, path)
+		}
+		return res
+	}
+
+	if isDirRequest && s.AutoIndex {
+		if dirPath, ok := s.resolvePath(docRoot, req_url); ok {
+			if info, err := os.Stat(dirPath); err == nil && info.IsDir() {
+				return s.HandleAutoIndex(req, dirPath, req_url, docRoot)
+			}
+		}
+	}
+
+	res.HandleNotFound(req)
+	return res
+}
+
+// matchVirtualHost returns the VirtualHost that best matches req (the
+// one with the longest matching PathPrefix), if any.
+func (s *Server) matchVirtualHost(req *Request) (vh VirtualHost, ok bool) {
+	for _, candidate := range s.VirtualHosts {
+		if candidate.Host != req.Host {
+			continue
+		}
+		if candidate.PathPrefix != "" && !strings.HasPrefix(req.URL, candidate.PathPrefix) {
+			continue
+		}
+		if !ok || len(candidate.PathPrefix) > len(vh.PathPrefix) {
+			vh, ok = candidate, true
+		}
 	}
+	return vh, ok
+}
+
+// resolvePath cleans url onto docRoot and confirms the result doesn't
+// escape docRoot (e.g. via ".."), returning ok=false if it does.
+func (s *Server) resolvePath(docRoot, url string) (path string, ok bool) {
+	path = filepath.Clean(docRoot + url)
 
-	url_split_concat := s.DocRoot + req_url
-	path := filepath.Clean(url_split_concat)
 	url_split := strings.Split(path, "/")
-	root_split := strings.Split(s.DocRoot, "/")
+	root_split := strings.Split(docRoot, "/")
 	for i, v := range root_split {
-		if url_split[i] != v {
-			res.HandleNotFound(req)
-			return res
+		if i >= len(url_split) || url_split[i] != v {
+			return "", false
 		}
 	}
 
-	// path := filepath.Clean(filepath.Join(s.DocRoot, req_url))
+	if _, err := filepath.Rel(docRoot, path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// autoIndexEntry describes one directory entry rendered by the
+// autoindex template.
+type autoIndexEntry struct {
+	Name    string
+	IsDir   bool
+	Size    int64
+	ModTime string
+}
+
+// autoIndexData is the data passed to the autoindex template.
+type autoIndexData struct {
+	Path    string
+	Entries []autoIndexEntry
+}
 
-	if _, err := filepath.Rel(s.DocRoot, path); err != nil {
+var defaultAutoIndexTemplate = template.Must(template.New("autoindex").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<ul>
+{{range .Entries}}<li><a href="{{.Name}}{{if .IsDir}}/{{end}}">{{.Name}}{{if .IsDir}}/{{end}}</a> - {{.Size}} bytes - {{.ModTime}}</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// HandleAutoIndex builds a directory listing response for dirPath
+// (urlPath is the request URL it was resolved from, used for the page
+// title and heading, with each entry linked by its bare name relative
+// to that URL, and docRoot is the root it was resolved
+// against). Symlinks that resolve outside docRoot are omitted from the
+// listing.
+func (s *Server) HandleAutoIndex(req *Request, dirPath, urlPath, docRoot string) *Response {
+	res := &Response{Proto: responseProto, Request: req}
+
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
 		res.HandleNotFound(req)
-	} else {
-		pathExist, _ := exists(path)
-		if pathExist {
-			fmt.Printf("%s\n", req_url)
-			fmt.Printf("%s\n", path)
-			res.HandleOK(req, path)
-		} else {
-			res.HandleNotFound(req)
+		return res
+	}
+
+	var entries []autoIndexEntry
+	for _, de := range dirEntries {
+		if de.Type()&os.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(filepath.Join(dirPath, de.Name()))
+			if err != nil {
+				continue
+			}
+			if rel, err := filepath.Rel(docRoot, target); err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+		}
+
+		info, err := de.Info()
+		if err != nil {
+			continue
 		}
+		entries = append(entries, autoIndexEntry{
+			Name:    de.Name(),
+			IsDir:   de.IsDir(),
+			Size:    info.Size(),
+			ModTime: FormatTime(info.ModTime()),
+		})
 	}
 
-	// Hint: use the other methods below
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	tmpl := s.AutoIndexTemplate
+	if tmpl == nil {
+		tmpl = defaultAutoIndexTemplate
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, autoIndexData{Path: urlPath, Entries: entries}); err != nil {
+		res.HandleNotFound(req)
+		return res
+	}
+
+	resMap := make(map[string]string)
+	resMap["Content-Type"] = "text/html; charset=utf-8"
+	resMap["Date"] = FormatTime(time.Now())
+	resMap["Content-Length"] = fmt.Sprint(buf.Len())
+	if req.Close {
+		resMap["Connection"] = "close"
+	}
 
+	res.StatusCode = statusOK
+	res.Header = resMap
+	res.Body = bytes.NewReader(buf.Bytes())
 	return res
 }
 
-// HandleOK prepares res to be a 200 OK response
-// ready to be written back to client.
+// fastCGIBackend returns the FastCGI backend configured for path's file
+// suffix, if any.
+func (s *Server) fastCGIBackend(path string) (FastCGIBackend, bool) {
+	ext := filepath.Ext(path)
+	if ext == "" {
+		return FastCGIBackend{}, false
+	}
+	backend, ok := s.FastCGI[ext]
+	return backend, ok
+}
+
+// HandleFastCGI forwards req to the given FastCGI responder and builds
+// the resulting res from the CGI response it streams back.
+func (s *Server) HandleFastCGI(req *Request, path string, backend FastCGIBackend) (res *Response) {
+	res = &Response{Proto: responseProto, Request: req}
+
+	scriptName := req.URL
+	queryString := ""
+	if i := strings.IndexByte(scriptName, '?'); i != -1 {
+		queryString = scriptName[i+1:]
+		scriptName = scriptName[:i]
+	}
+
+	params := map[string]string{
+		"REQUEST_METHOD":  req.Method,
+		"SCRIPT_FILENAME": path,
+		"SCRIPT_NAME":     scriptName,
+		"QUERY_STRING":    queryString,
+		"SERVER_PROTOCOL": req.Proto,
+		"HTTP_HOST":       req.Host,
+	}
+	for _, f := range req.Header {
+		params["HTTP_"+strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))] = f.Value
+	}
+
+	client := fcgi.NewClient(backend.Network, backend.Addr)
+	statusCode, cgiHeader, body, err := client.Do(params, nil)
+	if err != nil {
+		log.Printf("fastcgi request for %v failed: %v", path, err)
+		res.HandleNotFound(req)
+		return res
+	}
+
+	resMap := make(map[string]string)
+	for key, val := range cgiHeader {
+		resMap[key] = val
+	}
+	resMap["Date"] = FormatTime(time.Now())
+	if req.Close {
+		resMap["Connection"] = "close"
+	}
+
+	// Content-Length isn't known up front: body streams straight off
+	// the FastCGI connection rather than being buffered in full here,
+	// so res.Write sends it with Transfer-Encoding: chunked instead.
+	res.StatusCode = statusCode
+	res.Header = resMap
+	res.Body = body
+	return res
+}
+
+// hopByHopHeaders are connection-specific headers that a proxy must not
+// forward verbatim between client and upstream (RFC 7230 section 6.1).
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailers", "Transfer-Encoding", "Upgrade",
+}
+
+// HandleReverseProxy forwards req to vh.Upstream and builds res from
+// the response it reads back.
+func (s *Server) HandleReverseProxy(req *Request, vh VirtualHost) (res *Response) {
+	res = &Response{Proto: responseProto, Request: req}
+
+	upstream, err := url.Parse(vh.Upstream)
+	if err != nil {
+		log.Printf("reverse proxy: bad upstream %q: %v", vh.Upstream, err)
+		res.HandleNotFound(req)
+		return res
+	}
+
+	conn, err := net.Dial("tcp", upstream.Host)
+	if err != nil {
+		log.Printf("reverse proxy: dial %v failed: %v", upstream.Host, err)
+		res.HandleNotFound(req)
+		return res
+	}
+
+	if err := writeProxyRequest(conn, req, upstream); err != nil {
+		log.Printf("reverse proxy: request to %v failed: %v", upstream.Host, err)
+		conn.Close()
+		res.HandleNotFound(req)
+		return res
+	}
+
+	br := bufio.NewReader(conn)
+	statusCode, header, body, err := readProxyResponse(conn, br)
+	if err != nil {
+		log.Printf("reverse proxy: response from %v failed: %v", upstream.Host, err)
+		conn.Close()
+		res.HandleNotFound(req)
+		return res
+	}
+
+	for _, h := range hopByHopHeaders {
+		delete(header, h)
+	}
+	if req.Close {
+		header["Connection"] = "close"
+	}
+
+	// body (and, via it, conn) is closed by Response.Write once the
+	// body has been streamed to our own client.
+	res.StatusCode = statusCode
+	res.Header = header
+	res.Body = body
+	return res
+}
+
+// writeProxyRequest rewrites req's request line and Host header for
+// upstream, strips hop-by-hop headers, adds the X-Forwarded-* chain,
+// and writes the result to w.
+func writeProxyRequest(w io.Writer, req *Request, upstream *url.URL) error {
+	bw := bufio.NewWriter(w)
+
+	path := req.URL
+	if prefix := strings.TrimSuffix(upstream.Path, "/"); prefix != "" {
+		path = prefix + path
+	}
+	if _, err := fmt.Fprintf(bw, "%s %s %s\r\n", req.Method, path, req.Proto); err != nil {
+		return err
+	}
+
+	headers := make(map[string]string, len(req.Header)+4)
+	for _, f := range req.Header {
+		headers[f.Name] = f.Value
+	}
+	for _, h := range hopByHopHeaders {
+		delete(headers, h)
+	}
+
+	headers["Host"] = upstream.Host
+	headers["X-Forwarded-Proto"] = "http"
+	headers["X-Forwarded-Host"] = req.Host
+
+	clientIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	if prior, ok := headers["X-Forwarded-For"]; ok {
+		headers["X-Forwarded-For"] = prior + ", " + clientIP
+	} else {
+		headers["X-Forwarded-For"] = clientIP
+	}
+
+	for key, val := range headers {
+		if _, err := fmt.Fprintf(bw, "%s: %s\r\n", key, val); err != nil {
+			return err
+		}
+	}
+	if _, err := bw.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// readProxyResponse reads a status line and headers off br and returns
+// body as a reader that streams the response body lazily, straight off
+// conn, rather than buffering it all in memory up front: a chunked
+// body is decoded on the fly by chunkedReader, a Content-Length body is
+// capped with io.LimitReader, and a bodyless response (neither header
+// present) is treated as empty unless the upstream said it's closing
+// the connection, in which case body reads until EOF. The caller must
+// Close the returned body once done with it (including on error) to
+// release conn.
+func readProxyResponse(conn net.Conn, br *bufio.Reader) (statusCode int, header map[string]string, body io.ReadCloser, err error) {
+	statusLine, err := ReadLine(br)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	fields := strings.SplitN(statusLine, " ", 3)
+	if len(fields) < 2 {
+		return 0, nil, nil, fmt.Errorf("bad status line: %v", statusLine)
+	}
+	statusCode, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("bad status code: %v", fields[1])
+	}
+
+	header = make(map[string]string)
+	for {
+		line, err := ReadLine(br)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if line == "" {
+			break
+		}
+		key, val, err := parseHeader(line)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		header[key] = val
+	}
+
+	var r io.Reader
+	switch {
+	case header["Transfer-Encoding"] == "chunked":
+		r = &chunkedReader{br: br}
+	case header["Content-Length"] != "":
+		n, cerr := strconv.Atoi(header["Content-Length"])
+		if cerr != nil {
+			return 0, nil, nil, fmt.Errorf("bad Content-Length: %v", header["Content-Length"])
+		}
+		r = io.LimitReader(br, int64(n))
+	case header["Connection"] == "close":
+		r = br
+	default:
+		header["Content-Length"] = "0"
+		r = bytes.NewReader(nil)
+	}
+
+	return statusCode, header, &proxyBody{r: r, conn: conn}, nil
+}
+
+// proxyBody adapts a reader over an upstream proxy connection (conn)
+// into an io.ReadCloser: Close releases conn once the caller is done
+// reading the body, whether it was consumed in full or abandoned early.
+type proxyBody struct {
+	r    io.Reader
+	conn net.Conn
+}
+
+func (b *proxyBody) Read(p []byte) (int, error) { return b.r.Read(p) }
+func (b *proxyBody) Close() error               { return b.conn.Close() }
+
+// chunkedReader lazily decodes a "Transfer-Encoding: chunked" stream
+// read off br one chunk at a time, so a caller can stream it onward
+// without readChunkedBody's whole-body-in-memory buffering.
+type chunkedReader struct {
+	br   *bufio.Reader
+	left int64 // bytes left to read in the chunk currently in progress
+	done bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.left == 0 {
+		sizeLine, err := ReadLine(c.br)
+		if err != nil {
+			return 0, err
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeLine), 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad chunk size: %v", sizeLine)
+		}
+		if size == 0 {
+			for {
+				line, err := ReadLine(c.br)
+				if err != nil {
+					return 0, err
+				}
+				if line == "" {
+					break
+				}
+			}
+			c.done = true
+			return 0, io.EOF
+		}
+		c.left = size
+	}
+
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.br.Read(p)
+	c.left -= int64(n)
+	if err != nil {
+		return n, err
+	}
+	if c.left == 0 {
+		if _, err := ReadLine(c.br); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// HandleOK prepares res to be a 200 OK response (or a 304, 206 or 416,
+// depending on the conditional/range headers on req) ready to be
+// written back to client.
 func (res *Response) HandleOK(req *Request, path string) {
-	res.StatusCode = statusOK
 	res.Proto = responseProto
-	res.FilePath = path
 	res.Request = req
-	resMap := make(map[string]string)
 
 	file, err := os.Stat(path)
 	if err != nil {
 		fmt.Println(err)
 	}
+	modTime := file.ModTime()
+	etag := weakETag(file.Size(), modTime)
 
+	resMap := make(map[string]string)
 	ext := "." + strings.SplitN(path, ".", 2)[1]
 	resMap["Content-Type"] = MIMETypeByExtension(ext)
 	resMap["Date"] = FormatTime(time.Now())
-	resMap["Content-Length"] = fmt.Sprint(file.Size())
-	resMap["Last-Modified"] = fmt.Sprint(file.ModTime())
+	resMap["Last-Modified"] = FormatTime(modTime)
+	resMap["ETag"] = etag
+	resMap["Accept-Ranges"] = "bytes"
 	if req.Close {
 		resMap["Connection"] = "close"
 	}
 
+	if notModified(req, modTime, etag) {
+		res.StatusCode = statusNotModified
+		res.Header = resMap
+		return
+	}
+
+	size := file.Size()
+	if rangeHeader, ok := req.Header.Get("Range"); ok {
+		start, length, ok := parseRange(rangeHeader, size)
+		if !ok {
+			res.StatusCode = statusRangeNotSatisfiable
+			resMap["Content-Range"] = fmt.Sprintf("bytes */%d", size)
+			res.Header = resMap
+			return
+		}
+
+		res.StatusCode = statusPartialContent
+		res.FilePath = path
+		res.hasRange = true
+		res.rangeStart = start
+		res.rangeLen = length
+		resMap["Content-Range"] = fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size)
+		resMap["Content-Length"] = fmt.Sprint(length)
+		res.Header = resMap
+		return
+	}
+
+	res.StatusCode = statusOK
+	res.FilePath = path
+	resMap["Content-Length"] = fmt.Sprint(size)
 	res.Header = resMap
+}
+
+// weakETag derives a weak validator from a file's size and modification
+// time, since content is not hashed.
+func weakETag(size int64, modTime time.Time) string {
+	return fmt.Sprintf(`W/"%x-%x"`, size, modTime.Unix())
+}
+
+// notModified reports whether req's conditional headers indicate the
+// client's cached copy is still current. If-None-Match takes
+// precedence over If-Modified-Since when both are present.
+func notModified(req *Request, modTime time.Time, etag string) bool {
+	if inm, ok := req.Header.Get("If-None-Match"); ok {
+		return inm == "*" || inm == etag
+	}
+	if ims, ok := req.Header.Get("If-Modified-Since"); ok {
+		since, err := time.Parse(time.RFC1123, ims)
+		if err == nil && !modTime.Truncate(time.Second).After(since) {
+			return true
+		}
+	}
+	return false
+}
 
+// parseRange parses a "Range: bytes=a-b" header (also accepting the
+// "a-" and "-b" forms) against a file of the given size. Multiple
+// ranges are not supported and are treated as unsatisfiable.
+func parseRange(header string, size int64) (start, length int64, ok bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return 0, 0, false
+	}
+	spec := strings.TrimPrefix(header, prefix)
+	if strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	if parts[0] == "" {
+		n, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, size > 0
+	}
+
+	s, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || s < 0 || s >= size {
+		return 0, 0, false
+	}
+
+	if parts[1] == "" {
+		return s, size - s, true
+	}
+
+	e, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || e < s {
+		return 0, 0, false
+	}
+	if e >= size {
+		e = size - 1
+	}
+	return s, e - s + 1, true
 }
 
 // HandleNotFound prepares res to be a 404 Not Found response